@@ -0,0 +1,252 @@
+// openshift-test-result-filter
+// Copyright (C) 2021 Honza Pokorny <honza@pokorny.ca>
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CacheOptions configures a persistent cache opened with OpenOriginCache.
+type CacheOptions struct {
+	// MaxBytes bounds how many bytes of file content are kept resident in
+	// memory at once; least-recently-used documents are evicted past
+	// that and reloaded from dir on demand. Zero means unbounded.
+	MaxBytes int64
+}
+
+// fileRecord is the persisted mtime/size for one indexed file, used on the
+// next OpenOriginCache call to decide whether it needs re-reading from
+// originSource.
+type fileRecord struct {
+	Path    string    `json:"path"`
+	ModTime time.Time `json:"modTime"`
+	Size    int64     `json:"size"`
+}
+
+// cacheManifest is the on-disk index of a persistent cache directory.
+// Fingerprint is originSource's git HEAD at the time the manifest was
+// written; a mismatch means the tree has been checked out to a different
+// ref, so the whole cache is dropped and rebuilt.
+type cacheManifest struct {
+	Fingerprint string       `json:"fingerprint"`
+	Files       []fileRecord `json:"files"`
+}
+
+func manifestPath(dir string) string {
+	return filepath.Join(dir, "manifest.json")
+}
+
+func blobPath(dir, path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(dir, "blobs", hex.EncodeToString(sum[:]))
+}
+
+// originFingerprint returns a coarse dependency fingerprint for
+// originSource: its git HEAD commit, when it's a git checkout. Trees that
+// aren't git checkouts have no fingerprint, and their cache is never
+// invalidated this way.
+func originFingerprint(originSource string) string {
+	out, err := exec.Command("git", "-C", originSource, "rev-parse", "HEAD").Output()
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+func loadManifest(dir string) cacheManifest {
+	contents, err := ioutil.ReadFile(manifestPath(dir))
+
+	if err != nil {
+		return cacheManifest{}
+	}
+
+	var m cacheManifest
+
+	if err := json.Unmarshal(contents, &m); err != nil {
+		return cacheManifest{}
+	}
+
+	return m
+}
+
+func saveManifest(dir string, m cacheManifest) error {
+	contents, err := json.Marshal(m)
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(manifestPath(dir), contents, 0o644)
+}
+
+// lru bounds how many bytes of document content OpenOriginCache keeps
+// resident in memory, evicting least-recently-used documents once
+// maxBytes is exceeded. Eviction just drops the in-memory copy: the
+// document reloads it from its on-disk blob the next time Lookup needs it.
+type lru struct {
+	maxBytes  int64
+	usedBytes int64
+	order     *list.List
+	elements  map[int]*list.Element
+}
+
+func newLRU(maxBytes int64) *lru {
+	return &lru{maxBytes: maxBytes, order: list.New(), elements: make(map[int]*list.Element)}
+}
+
+// touch marks docID as most-recently-used, then evicts from the back of
+// the list until usedBytes is back within budget. Touching a docID that's
+// already tracked only updates recency: its bytes were already counted, and
+// its size can't have changed without an evict() in between, which removes
+// it from elements first.
+func (l *lru) touch(index *SourceIndex, docID int) {
+	if el, ok := l.elements[docID]; ok {
+		l.order.MoveToFront(el)
+		return
+	}
+
+	l.elements[docID] = l.order.PushFront(docID)
+	l.usedBytes += index.docs[docID].size()
+
+	if l.maxBytes <= 0 {
+		return
+	}
+
+	for l.usedBytes > l.maxBytes {
+		back := l.order.Back()
+
+		if back == nil {
+			return
+		}
+
+		evictID := back.Value.(int)
+		doc := index.docs[evictID]
+
+		l.usedBytes -= doc.size()
+		doc.evict()
+
+		l.order.Remove(back)
+		delete(l.elements, evictID)
+	}
+}
+
+// OpenOriginCache opens, or creates, a persistent cache under dir for the
+// .go files under originSource. A file is only re-read from originSource
+// when its mtime or size differs from what was recorded the last time
+// OpenOriginCache ran against dir; otherwise its contents come from dir's
+// own copy. At most opts.MaxBytes of content is kept resident in memory
+// at once, least-recently-used first; anything evicted is transparently
+// reloaded from dir the next time it's needed. The cache is dropped and
+// rebuilt whenever originSource's git HEAD changes. FindStringInCache and
+// FindTestSource work against the returned OriginCache exactly as they do
+// against one built by CreateOriginTestCache.
+func OpenOriginCache(originSource, dir string, opts CacheOptions) (OriginCache, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "blobs"), 0o755); err != nil {
+		return OriginCache{}, err
+	}
+
+	fingerprint := originFingerprint(originSource)
+	priorManifest := loadManifest(dir)
+
+	if priorManifest.Fingerprint != fingerprint {
+		priorManifest = cacheManifest{}
+	}
+
+	known := make(map[string]fileRecord, len(priorManifest.Files))
+
+	for _, f := range priorManifest.Files {
+		known[f.Path] = f
+	}
+
+	index := NewSourceIndex()
+	index.evictor = newLRU(opts.MaxBytes)
+	var updated []fileRecord
+
+	err := filepath.WalkDir(originSource, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		if !strings.HasSuffix(path, ".go") || strings.Contains(path, "zz_generated") {
+			return nil
+		}
+
+		info, err := d.Info()
+
+		if err != nil {
+			return err
+		}
+
+		rec := fileRecord{Path: path, ModTime: info.ModTime(), Size: info.Size()}
+		updated = append(updated, rec)
+
+		blob := blobPath(dir, path)
+
+		var contents []byte
+
+		if existing, ok := known[path]; ok && existing.ModTime.Equal(rec.ModTime) && existing.Size == rec.Size {
+			contents, _ = ioutil.ReadFile(blob)
+		}
+
+		if contents == nil {
+			contents, err = ioutil.ReadFile(path)
+
+			if err != nil {
+				return err
+			}
+
+			if err := ioutil.WriteFile(blob, contents, 0o644); err != nil {
+				return err
+			}
+		}
+
+		index.addDocumentWithReload(path, string(contents), func() (string, error) {
+			reloaded, err := ioutil.ReadFile(blob)
+			return string(reloaded), err
+		})
+
+		index.touch(len(index.docs) - 1)
+
+		return nil
+	})
+
+	if err != nil {
+		return OriginCache{}, err
+	}
+
+	if err := saveManifest(dir, cacheManifest{Fingerprint: fingerprint, Files: updated}); err != nil {
+		return OriginCache{}, err
+	}
+
+	return OriginCache{index: index}, nil
+}
@@ -0,0 +1,292 @@
+// openshift-test-result-filter
+// Copyright (C) 2021 Honza Pokorny <honza@pokorny.ca>
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/joshdk/go-junit"
+)
+
+// FormattedResult pairs a TestCase with its resolved source location, the
+// unit every Formatter renders. SourceFound must only be true when
+// SourceLocation is a genuine match: JSON and SARIF output serialize it as
+// authoritative, machine-consumed data, so a false positive here surfaces
+// as a confidently wrong file/line in downstream tooling.
+type FormattedResult struct {
+	TestCase       TestCase
+	SourceLocation SourceLocation
+	SourceFound    bool
+}
+
+// Formatter renders a filtered, source-resolved set of TestCase entries to
+// w. One implementation exists per -format value.
+type Formatter interface {
+	Format(w io.Writer, results []FormattedResult) error
+}
+
+// newFormatter builds the Formatter named by format, which must be one of
+// "text", "json", "junit", or "sarif".
+func newFormatter(format string, showErrors bool, resultFilter string) (Formatter, error) {
+	switch format {
+	case "", "text":
+		return TextFormatter{ShowErrors: showErrors, ResultFilter: resultFilter}, nil
+	case "json":
+		return JSONFormatter{}, nil
+	case "junit":
+		return JUnitFormatter{}, nil
+	case "sarif":
+		return SARIFFormatter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown -format: %s", format)
+	}
+}
+
+// TextFormatter reproduces the tool's original ad-hoc "context:"/"tags:"/"-"
+// output.
+type TextFormatter struct {
+	ShowErrors   bool
+	ResultFilter string
+}
+
+func (f TextFormatter) Format(w io.Writer, results []FormattedResult) error {
+	for _, r := range results {
+		fmt.Fprintln(w, r.TestCase.SimpleName)
+		fmt.Fprintln(w, "context:", r.TestCase.Context)
+
+		if len(r.TestCase.Tags) > 0 {
+			fmt.Fprintln(w, "tags:")
+			for _, tag := range r.TestCase.Tags {
+				fmt.Fprintln(w, " -", tag)
+			}
+		}
+
+		if r.SourceFound {
+			fmt.Fprintln(w, r.SourceLocation.PrettyString())
+		} else {
+			fmt.Fprintln(w, "Source not found")
+		}
+
+		if f.ShowErrors && (f.ResultFilter == "failed" || f.ResultFilter == "all") {
+			fmt.Fprintln(w, "ERROR:")
+			fmt.Fprintln(w, r.TestCase.Test.Error)
+		}
+
+		fmt.Fprintln(w, "-")
+	}
+
+	return nil
+}
+
+// JSONFormatter emits the filtered TestCase entries, including their
+// resolved SourceLocation, as a single JSON array.
+type JSONFormatter struct{}
+
+type jsonTestCase struct {
+	TestCase
+	SourceLocation *SourceLocation `json:"SourceLocation,omitempty"`
+}
+
+func (f JSONFormatter) Format(w io.Writer, results []FormattedResult) error {
+	out := make([]jsonTestCase, 0, len(results))
+
+	for _, r := range results {
+		jtc := jsonTestCase{TestCase: r.TestCase}
+
+		if r.SourceFound {
+			sl := r.SourceLocation
+			jtc.SourceLocation = &sl
+		}
+
+		out = append(out, jtc)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(out)
+}
+
+// JUnitFormatter re-emits the filtered tests as a junit.xml document, so
+// the output can be fed into other JUnit tooling.
+type JUnitFormatter struct{}
+
+type junitXMLTestsuites struct {
+	XMLName xml.Name        `xml:"testsuites"`
+	Suites  []junitXMLSuite `xml:"testsuite"`
+}
+
+type junitXMLSuite struct {
+	Name      string             `xml:"name,attr"`
+	Tests     int                `xml:"tests,attr"`
+	Failures  int                `xml:"failures,attr"`
+	Errors    int                `xml:"errors,attr"`
+	Skipped   int                `xml:"skipped,attr"`
+	TestCases []junitXMLTestCase `xml:"testcase"`
+}
+
+type junitXMLTestCase struct {
+	Name      string           `xml:"name,attr"`
+	Classname string           `xml:"classname,attr"`
+	Time      string           `xml:"time,attr"`
+	Failure   *junitXMLMessage `xml:"failure,omitempty"`
+	Error     *junitXMLMessage `xml:"error,omitempty"`
+	Skipped   *junitXMLMessage `xml:"skipped,omitempty"`
+}
+
+type junitXMLMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+func (f JUnitFormatter) Format(w io.Writer, results []FormattedResult) error {
+	suite := junitXMLSuite{Name: "filtered", Tests: len(results)}
+
+	for _, r := range results {
+		tc := r.TestCase
+
+		jtc := junitXMLTestCase{
+			Name:      tc.Name,
+			Classname: tc.Test.Classname,
+			Time:      fmt.Sprintf("%.3f", tc.Test.Duration.Seconds()),
+		}
+
+		switch tc.Test.Status {
+		case junit.StatusFailed:
+			jtc.Failure = &junitXMLMessage{Message: tc.Test.Message, Body: testErrorMessage(tc)}
+			suite.Failures++
+		case junit.StatusError:
+			jtc.Error = &junitXMLMessage{Message: tc.Test.Message, Body: testErrorMessage(tc)}
+			suite.Errors++
+		case junit.StatusSkipped:
+			jtc.Skipped = &junitXMLMessage{Message: tc.Test.Message}
+			suite.Skipped++
+		}
+
+		suite.TestCases = append(suite.TestCases, jtc)
+	}
+
+	doc := junitXMLTestsuites{Suites: []junitXMLSuite{suite}}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+
+	return err
+}
+
+// SARIFFormatter wraps failed tests as SARIF 2.1.0 results, so GitHub code
+// scanning and similar dashboards can surface e2e failures inline on PRs.
+type SARIFFormatter struct{}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name string `json:"name"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+func (f SARIFFormatter) Format(w io.Writer, results []FormattedResult) error {
+	run := sarifRun{Tool: sarifTool{Driver: sarifDriver{Name: "openshift-test-result-filter"}}}
+
+	for _, r := range results {
+		if !r.TestCase.IsFailed() {
+			continue
+		}
+
+		sarifResult := sarifResult{
+			RuleID:  "e2e-test-failure",
+			Level:   "error",
+			Message: sarifMessage{Text: testErrorMessage(r.TestCase)},
+		}
+
+		if r.SourceFound {
+			sarifResult.Locations = append(sarifResult.Locations, sarifLocation{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: strings.TrimPrefix(r.SourceLocation.Path, "/")},
+					Region:           sarifRegion{StartLine: r.SourceLocation.LineNumber},
+				},
+			})
+		}
+
+		run.Results = append(run.Results, sarifResult)
+	}
+
+	doc := sarifLog{
+		Schema:  "https://raw.githubusercontent.com/oasis-tcs/sarif-spec/master/Schemata/sarif-schema-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(doc)
+}
@@ -0,0 +1,272 @@
+// openshift-test-result-filter
+// Copyright (C) 2021 Honza Pokorny <honza@pokorny.ca>
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// document holds the contents of a single indexed file, keyed by its
+// position in SourceIndex.docs (its docID). contents can be evicted to
+// bound memory use; reload, when set, re-populates it on demand (e.g. from
+// a persistent on-disk cache opened with OpenOriginCache). Documents added
+// via addDocument have reload == nil and are always resident.
+type document struct {
+	path     string
+	contents string
+	reload   func() (string, error)
+}
+
+// load returns the document's contents, reloading them first if they were
+// evicted.
+func (d *document) load() (string, error) {
+	if d.contents != "" || d.reload == nil {
+		return d.contents, nil
+	}
+
+	contents, err := d.reload()
+
+	if err != nil {
+		return "", err
+	}
+
+	d.contents = contents
+
+	return contents, nil
+}
+
+// evict drops the document's in-memory contents. It is a no-op for
+// documents with no reload func, since they have nowhere else to load
+// their contents back from.
+func (d *document) evict() {
+	if d.reload != nil {
+		d.contents = ""
+	}
+}
+
+// size returns how many bytes of contents this document currently holds
+// resident in memory.
+func (d *document) size() int64 {
+	return int64(len(d.contents))
+}
+
+// SourceIndex is a trigram posting-list index over a tree of source files,
+// following the approach used by tools like Zoekt and Russ Cox's
+// codesearch: every 3-byte window of a file's contents is recorded against
+// the file's docID, so a query can be narrowed to a small candidate set by
+// intersecting the posting lists of its own trigrams before ever scanning
+// file contents.
+type SourceIndex struct {
+	docs     []*document
+	postings map[string][]int
+
+	// evictor, when set by OpenOriginCache, bounds how many bytes of doc
+	// contents stay resident across the index's whole lifetime, not just
+	// while it's being built. nil means unbounded, as for an index built
+	// by CreateOriginTestCache/Build.
+	evictor *lru
+}
+
+// NewSourceIndex returns an empty index ready to be populated with Build.
+func NewSourceIndex() *SourceIndex {
+	return &SourceIndex{postings: make(map[string][]int)}
+}
+
+// trigrams returns the distinct 3-byte windows of s, in order of first
+// occurrence. Callers must check the length of the result: strings shorter
+// than 3 bytes have none, and must be handled with a fallback scan.
+func trigrams(s string) []string {
+	if len(s) < 3 {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var out []string
+
+	for i := 0; i+3 <= len(s); i++ {
+		t := s[i : i+3]
+
+		if seen[t] {
+			continue
+		}
+
+		seen[t] = true
+		out = append(out, t)
+	}
+
+	return out
+}
+
+// intersect returns the sorted intersection of two sorted, ascending docID
+// lists.
+func intersect(a, b []int) []int {
+	var out []int
+	i, j := 0, 0
+
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, a[i])
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+
+	return out
+}
+
+// touch marks docID as most-recently-used against si's evictor, if one is
+// set, possibly evicting other documents to stay within its budget. It is
+// a no-op for an index with no evictor.
+func (si *SourceIndex) touch(docID int) {
+	if si.evictor == nil {
+		return
+	}
+
+	si.evictor.touch(si, docID)
+}
+
+// loadDoc returns docID's contents, reloading them if they were evicted,
+// and touches it so eviction stays driven by actual access rather than
+// just the initial build walk.
+func (si *SourceIndex) loadDoc(docID int) (string, error) {
+	contents, err := si.docs[docID].load()
+
+	if err != nil {
+		return "", err
+	}
+
+	si.touch(docID)
+
+	return contents, nil
+}
+
+// addDocument adds contents to the index under path, recording every
+// trigram it contains against the new docID. The document is always
+// resident; use addDocumentWithReload for one that can be evicted.
+func (si *SourceIndex) addDocument(path, contents string) {
+	si.addDocumentWithReload(path, contents, nil)
+}
+
+// addDocumentWithReload is like addDocument, but the document's contents
+// can later be evicted from memory and transparently reloaded with reload.
+func (si *SourceIndex) addDocumentWithReload(path, contents string, reload func() (string, error)) {
+	docID := len(si.docs)
+	si.docs = append(si.docs, &document{path: path, contents: contents, reload: reload})
+
+	for _, t := range trigrams(contents) {
+		si.postings[t] = append(si.postings[t], docID)
+	}
+}
+
+// Build walks root the same way CreateOriginTestCache does, indexing every
+// non-generated .go file it finds.
+func (si *SourceIndex) Build(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		if !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+
+		if strings.Contains(path, "zz_generated") {
+			return nil
+		}
+
+		contents, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			return err
+		}
+
+		si.addDocument(path, string(contents))
+
+		return nil
+	})
+}
+
+// candidates returns the docIDs that could possibly contain pattern,
+// narrowed by intersecting the posting lists of pattern's trigrams. If
+// pattern is too short to have any trigrams, every doc is a candidate and
+// the caller falls back to a plain scan.
+func (si *SourceIndex) candidates(pattern string) []int {
+	trigs := trigrams(pattern)
+
+	if len(trigs) == 0 {
+		all := make([]int, len(si.docs))
+		for i := range si.docs {
+			all[i] = i
+		}
+		return all
+	}
+
+	result := si.postings[trigs[0]]
+
+	for _, t := range trigs[1:] {
+		if len(result) == 0 {
+			break
+		}
+		result = intersect(result, si.postings[t])
+	}
+
+	return result
+}
+
+// Lookup finds the first indexed file containing pattern and returns its
+// path and the 1-based line number of the match. The candidate set is
+// narrowed via the trigram index first; the match itself, and the line
+// count, still require a verification scan of the candidates' contents.
+func (si *SourceIndex) Lookup(pattern string) (path string, line int, ok bool) {
+	r, err := regexp.Compile(regexp.QuoteMeta(pattern))
+
+	if err != nil {
+		return "", 0, false
+	}
+
+	for _, docID := range si.candidates(pattern) {
+		contents, err := si.loadDoc(docID)
+
+		if err != nil {
+			continue
+		}
+
+		loc := r.FindStringIndex(contents)
+
+		if loc == nil {
+			continue
+		}
+
+		above := contents[:loc[0]]
+		return si.docs[docID].path, len(strings.Split(above, "\n")), true
+	}
+
+	return "", 0, false
+}
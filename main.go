@@ -19,10 +19,7 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io/fs"
-	"io/ioutil"
 	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
 
@@ -58,6 +55,11 @@ func (tc TestCase) IsFailed() bool {
 type SourceLocation struct {
 	Path       string
 	LineNumber int
+
+	// Ref is the git ref (sha or branch) the location was resolved
+	// against, and what GitHubLink points the reader at. Defaults to
+	// "master" when unset.
+	Ref string
 }
 
 func (sl SourceLocation) PrettyString() string {
@@ -65,7 +67,13 @@ func (sl SourceLocation) PrettyString() string {
 }
 
 func (sl SourceLocation) GitHubLink() string {
-	return fmt.Sprintf("https://github.com/openshift/origin/blob/master%s#L%d", sl.Path, sl.LineNumber)
+	ref := sl.Ref
+
+	if ref == "" {
+		ref = "master"
+	}
+
+	return fmt.Sprintf("https://github.com/openshift/origin/blob/%s%s#L%d", ref, sl.Path, sl.LineNumber)
 }
 
 func GetSimpleName(name string, context string, tags []string) string {
@@ -137,66 +145,60 @@ func ParseTags(name string) []string {
 
 }
 
-type OriginCache map[string]string
+// OriginCache wraps a trigram-indexed SourceIndex over the origin tree.
+// FindStringInCache and FindTestSource still work in terms of OriginCache so
+// callers are unaffected by the switch from a linear scan to an index.
+type OriginCache struct {
+	index *SourceIndex
+}
 
 func CreateOriginTestCache(originSource string) (OriginCache, error) {
-	cache := make(map[string]string)
+	index := NewSourceIndex()
 
-	err := filepath.WalkDir(originSource, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
+	if err := index.Build(originSource); err != nil {
+		return OriginCache{}, err
+	}
 
-		if d.IsDir() {
-			return nil
-		}
+	return OriginCache{index: index}, nil
+}
 
-		if !strings.HasSuffix(path, ".go") {
-			return nil
-		}
+// findStringLinear scans every cached file in order, for patterns too short
+// to decompose into trigrams.
+func findStringLinear(cache OriginCache, pattern string) (bool, string, int, error) {
+	r, err := regexp.Compile(regexp.QuoteMeta(pattern))
 
-		if strings.Contains(path, "zz_generated") {
-			return nil
-		}
+	if err != nil {
+		return false, "", 0, err
+	}
 
-		contents, err := ioutil.ReadFile(path)
+	for docID, doc := range cache.index.docs {
+		contents, err := cache.index.loadDoc(docID)
 
 		if err != nil {
-			return err
+			continue
 		}
 
-		cache[path] = string(contents)
+		loc := r.FindStringIndex(contents)
 
-		return nil
-	})
+		if len(loc) == 0 {
+			continue
+		}
 
-	if err != nil {
-		return cache, err
+		above := contents[:loc[0]]
+		return true, doc.path, len(strings.Split(above, "\n")), nil
 	}
 
-	return cache, nil
+	return false, "", 0, nil
 }
 
 func FindStringInCache(cache OriginCache, pattern string) (matched bool, path string, lineNumber int, err error) {
-	for path, contents := range cache {
-		r, err := regexp.Compile(regexp.QuoteMeta(pattern))
-
-		if err != nil {
-			return false, "", 0, err
-		}
-
-		loc := r.FindStringIndex(contents)
-
-		if len(loc) > 0 {
-			above := string(contents[:loc[0]])
-			lineCountAbove := len(strings.Split(above, "\n"))
-
-			return true, path, lineCountAbove, nil
-		}
+	if len(pattern) < 3 {
+		return findStringLinear(cache, pattern)
 	}
 
-	return false, "", 0, nil
+	path, lineNumber, ok := cache.index.Lookup(pattern)
 
+	return ok, path, lineNumber, nil
 }
 
 func FindTestSource(originSource string, cache OriginCache, tc TestCase) (bool, SourceLocation, error) {
@@ -204,7 +206,9 @@ func FindTestSource(originSource string, cache OriginCache, tc TestCase) (bool,
 
 	words := strings.Split(tc.Name, " ")
 
-	for i := len(words); i >= 0; i-- {
+	found := false
+
+	for i := len(words); i >= 1; i-- {
 		stringToTry := strings.Join(words[:i], " ")
 
 		matched, path, lineNumber, err := FindStringInCache(cache, stringToTry)
@@ -221,11 +225,52 @@ func FindTestSource(originSource string, cache OriginCache, tc TestCase) (bool,
 			Path:       strings.ReplaceAll(path, originSource, ""),
 			LineNumber: lineNumber,
 		}
+		found = true
 		break
 
 	}
 
-	return true, sl, nil
+	return found, sl, nil
+}
+
+// testErrorMessage returns the textual description of tc's failure, or the
+// empty string for a test that didn't fail or error.
+func testErrorMessage(tc TestCase) string {
+	if tc.Test.Error == nil {
+		return ""
+	}
+
+	return tc.Test.Error.Error()
+}
+
+// matchesFilter reports whether tc passes the -tag/-result style filter
+// shared by the CLI loop in main and the HTTP API in Server.
+func matchesFilter(tc TestCase, tag, result string) bool {
+	if tag != "" {
+		found := false
+
+		for _, t := range tc.Tags {
+			if tag == t {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	switch result {
+	case "skipped":
+		return tc.IsSkipped()
+	case "failed":
+		return tc.IsFailed()
+	case "passed":
+		return tc.IsPassed()
+	default:
+		return true
+	}
 }
 
 var tag = flag.String("tag", "", "Tag, e.g. sig-storage")
@@ -233,6 +278,27 @@ var result = flag.String("result", "all", "choices: all, skipped, failed, passed
 var filename = flag.String("filename", "", "input junit file")
 var showErrors = flag.Bool("show-errors", false, "")
 var originTreePath = flag.String("origin-tree-path", "", "")
+var originSourceFlag = flag.String("origin-source", "local", "where to resolve test source locations from: local or github")
+var originRef = flag.String("origin-ref", "master", "git ref (sha or branch) to resolve source locations against, and to link to on GitHub")
+var serve = flag.String("serve", "", "serve an interactive browser UI on this address, e.g. :8080, instead of printing to stdout")
+var cacheDir = flag.String("cache-dir", "", "persist the origin file cache under this directory across runs, instead of re-reading the whole tree every time")
+var cacheMaxBytes = flag.Int64("cache-max-bytes", 0, "bound how much origin file content is kept resident in memory when -cache-dir is set (0 = unbounded)")
+var format = flag.String("format", "text", "output format: text, json, junit, or sarif")
+
+func newOriginResolver() (OriginResolver, error) {
+	switch *originSourceFlag {
+	case "local":
+		if *originTreePath == "" {
+			return nil, fmt.Errorf("missing origin-tree-path")
+		}
+
+		return NewLocalOriginResolver(*originTreePath, *originRef, *cacheDir, CacheOptions{MaxBytes: *cacheMaxBytes})
+	case "github":
+		return NewRemoteOriginResolver(*originRef)
+	default:
+		return nil, fmt.Errorf("unknown -origin-source: %s", *originSourceFlag)
+	}
+}
 
 func main() {
 	flag.Parse()
@@ -242,90 +308,57 @@ func main() {
 		os.Exit(1)
 	}
 
-	if *originTreePath == "" {
-		fmt.Println("missing origin-tree-path")
+	entries, err := LoadData(*filename)
+
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	entries, err := LoadData(*filename)
+	resolver, err := newOriginResolver()
 
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	var cache OriginCache
-
-	if *originTreePath != "" {
-		cache, err = CreateOriginTestCache(*originTreePath)
+	if *serve != "" {
+		server := NewServer(entries, resolver)
 
-		if err != nil {
+		if err := server.ListenAndServe(*serve); err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
+
+		return
 	}
 
-	for _, entry := range entries {
-		if *tag != "" {
-			foundTag := false
-			for _, t := range entry.Tags {
-				if *tag == t {
-					foundTag = true
-					break
-				}
-			}
-			if !foundTag {
-				continue
-			}
-		}
+	formatter, err := newFormatter(*format, *showErrors, *result)
 
-		if *result == "skipped" && !entry.IsSkipped() {
-			continue
-		}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-		if *result == "failed" && !entry.IsFailed() {
-			continue
-		}
+	var results []FormattedResult
 
-		if *result == "passed" && !entry.IsPassed() {
+	for _, entry := range entries {
+		if !matchesFilter(entry, *tag, *result) {
 			continue
 		}
 
-		fmt.Println(entry.SimpleName)
-		fmt.Println("context:", entry.Context)
+		found, sl, err := resolver.FindTestSource(entry)
 
-		if len(entry.Tags) > 0 {
-			fmt.Println("tags:")
-			for _, tag := range entry.Tags {
-				fmt.Println(" -", tag)
-			}
-		}
-
-		if *originTreePath != "" {
-
-			found, sl, err := FindTestSource(*originTreePath, cache, entry)
-
-			if err != nil {
-				fmt.Println("ERR:", err)
-			}
-
-			if found {
-				fmt.Println(sl.PrettyString())
-			} else {
-				fmt.Println("Source not found")
-			}
-
-		}
-
-		if *result == "failed" || *result == "all" {
-			if *showErrors {
-				fmt.Println("ERROR:")
-				fmt.Println(entry.Test.Error)
-			}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ERR:", err)
 		}
 
-		fmt.Println("-")
+		results = append(results, FormattedResult{TestCase: entry, SourceLocation: sl, SourceFound: found})
+	}
 
+	if err := formatter.Format(os.Stdout, results); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 
 }
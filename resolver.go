@@ -0,0 +1,323 @@
+// openshift-test-result-filter
+// Copyright (C) 2021 Honza Pokorny <honza@pokorny.ca>
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const githubOwner = "openshift"
+const githubRepo = "origin"
+
+// OriginResolver resolves a TestCase to its location in the origin source
+// tree. LocalOriginResolver and RemoteOriginResolver are the two
+// implementations, selected at the command line with -origin-source.
+type OriginResolver interface {
+	FindTestSource(tc TestCase) (bool, SourceLocation, error)
+}
+
+// LocalOriginResolver resolves against a local checkout of origin, backed
+// by the trigram-indexed OriginCache built by CreateOriginTestCache, or by
+// OpenOriginCache when cacheDir is set.
+type LocalOriginResolver struct {
+	originSource string
+	originRef    string
+	cache        OriginCache
+}
+
+func NewLocalOriginResolver(originSource, originRef, cacheDir string, cacheOpts CacheOptions) (*LocalOriginResolver, error) {
+	var cache OriginCache
+	var err error
+
+	if cacheDir != "" {
+		cache, err = OpenOriginCache(originSource, cacheDir, cacheOpts)
+	} else {
+		cache, err = CreateOriginTestCache(originSource)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalOriginResolver{originSource: originSource, originRef: originRef, cache: cache}, nil
+}
+
+func (r *LocalOriginResolver) FindTestSource(tc TestCase) (bool, SourceLocation, error) {
+	found, sl, err := FindTestSource(r.originSource, r.cache, tc)
+
+	sl.Ref = r.originRef
+
+	return found, sl, err
+}
+
+// RemoteOriginResolver resolves against the openshift/origin GitHub repo
+// without requiring a local checkout: candidate files are discovered
+// lazily via the GitHub code search API and their contents are fetched via
+// the raw content endpoint, pinned to the commit sha's ref resolves to.
+// Fetched blobs are cached on disk under
+// $XDG_CACHE_HOME/openshift-test-result-filter/<sha>/ so repeated runs
+// against the same commit are cheap. Keying by sha rather than by ref
+// matters for a moving ref like "master": caching under the ref name would
+// keep serving content from whatever commit happened to be HEAD the first
+// time the cache was populated, silently going stale as ref moves on.
+type RemoteOriginResolver struct {
+	ref      string
+	sha      string
+	cacheDir string
+	client   *http.Client
+}
+
+func NewRemoteOriginResolver(originRef string) (*RemoteOriginResolver, error) {
+	base, err := originCacheDir()
+
+	if err != nil {
+		return nil, err
+	}
+
+	client := http.DefaultClient
+
+	sha, err := resolveCommitSHA(client, originRef)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &RemoteOriginResolver{
+		ref:      originRef,
+		sha:      sha,
+		cacheDir: filepath.Join(base, sha),
+		client:   client,
+	}, nil
+}
+
+// isFullSHA reports whether ref already names a specific commit, in which
+// case it's stable and needs no resolution.
+func isFullSHA(ref string) bool {
+	if len(ref) != 40 {
+		return false
+	}
+
+	for _, c := range ref {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type commitResponse struct {
+	SHA string `json:"sha"`
+}
+
+// resolveCommitSHA pins a possibly-moving ref (a branch or tag name) to the
+// commit sha it names right now, via the GitHub commits API. A ref that's
+// already a full sha is returned unchanged.
+func resolveCommitSHA(client *http.Client, ref string) (string, error) {
+	if isFullSHA(ref) {
+		return ref, nil
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://api.github.com/repos/%s/%s/commits/%s", githubOwner, githubRepo, url.PathEscape(ref)), nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("resolve ref %s: unexpected status %s", ref, resp.Status)
+	}
+
+	var parsed commitResponse
+
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return "", err
+	}
+
+	return parsed.SHA, nil
+}
+
+func originCacheDir() (string, error) {
+	base := os.Getenv("XDG_CACHE_HOME")
+
+	if base == "" {
+		home, err := os.UserHomeDir()
+
+		if err != nil {
+			return "", err
+		}
+
+		base = filepath.Join(home, ".cache")
+	}
+
+	return filepath.Join(base, "openshift-test-result-filter"), nil
+}
+
+type codeSearchResponse struct {
+	Items []struct {
+		Path string `json:"path"`
+	} `json:"items"`
+}
+
+// searchCandidates asks the GitHub code search API for files in
+// openshift/origin that mention the quoted prefix of a test name.
+func (r *RemoteOriginResolver) searchCandidates(prefix string) ([]string, error) {
+	query := fmt.Sprintf("%q repo:%s/%s", prefix, githubOwner, githubRepo)
+
+	req, err := http.NewRequest("GET", "https://api.github.com/search/code?q="+url.QueryEscape(query), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	}
+
+	resp, err := r.client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("github code search: unexpected status %s", resp.Status)
+	}
+
+	var parsed codeSearchResponse
+
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, len(parsed.Items))
+
+	for i, item := range parsed.Items {
+		paths[i] = item.Path
+	}
+
+	return paths, nil
+}
+
+// fetchBlob returns the contents of path at r.ref, reading from the on-disk
+// cache when present and populating it otherwise.
+func (r *RemoteOriginResolver) fetchBlob(path string) (string, error) {
+	cachePath := filepath.Join(r.cacheDir, path)
+
+	if cached, err := ioutil.ReadFile(cachePath); err == nil {
+		return string(cached), nil
+	}
+
+	u := fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s/%s", githubOwner, githubRepo, r.sha, path)
+
+	resp, err := r.client.Get(u)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetch %s: unexpected status %s", u, resp.Status)
+	}
+
+	contents, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err != nil {
+		return "", err
+	}
+
+	if err := ioutil.WriteFile(cachePath, contents, 0o644); err != nil {
+		return "", err
+	}
+
+	return string(contents), nil
+}
+
+func (r *RemoteOriginResolver) FindTestSource(tc TestCase) (bool, SourceLocation, error) {
+	sl := SourceLocation{Ref: r.ref}
+
+	words := strings.Split(tc.Name, " ")
+
+	for i := len(words); i >= 1; i-- {
+		prefix := strings.Join(words[:i], " ")
+
+		if len(prefix) < 3 {
+			continue
+		}
+
+		paths, err := r.searchCandidates(prefix)
+
+		if err != nil {
+			return false, sl, err
+		}
+
+		for _, path := range paths {
+			contents, err := r.fetchBlob(path)
+
+			if err != nil {
+				continue
+			}
+
+			idx := strings.Index(contents, prefix)
+
+			if idx < 0 {
+				continue
+			}
+
+			above := contents[:idx]
+
+			sl.Path = "/" + path
+			sl.LineNumber = len(strings.Split(above, "\n"))
+
+			return true, sl, nil
+		}
+	}
+
+	return false, sl, nil
+}
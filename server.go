@@ -0,0 +1,327 @@
+// openshift-test-result-filter
+// Copyright (C) 2021 Honza Pokorny <honza@pokorny.ca>
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server serves a JUnit file that has already been loaded with LoadData,
+// over HTTP: an HTML UI for browsing and filtering, plus JSON endpoints at
+// /api/tests for scripting. It wraps the same TestCase/OriginResolver
+// pieces the CLI in main uses.
+type Server struct {
+	entries  []TestCase
+	resolver OriginResolver
+
+	sourceMu    sync.Mutex
+	sourceCache map[int]cachedSource
+}
+
+// cachedSource is one entry's resolved source location, memoized by
+// resolveSource so a page refresh doesn't re-resolve every row. This
+// matters most under -origin-source=github, where resolving is a GitHub
+// API call per test.
+type cachedSource struct {
+	found bool
+	sl    SourceLocation
+}
+
+func NewServer(entries []TestCase, resolver OriginResolver) *Server {
+	return &Server{entries: entries, resolver: resolver, sourceCache: make(map[int]cachedSource)}
+}
+
+// resolveSource resolves entry id's source location, memoizing the result
+// across requests so repeated page loads don't repeat the (possibly
+// network-bound) resolution.
+func (s *Server) resolveSource(id int) (bool, SourceLocation, error) {
+	s.sourceMu.Lock()
+	cached, ok := s.sourceCache[id]
+	s.sourceMu.Unlock()
+
+	if ok {
+		return cached.found, cached.sl, nil
+	}
+
+	found, sl, err := s.resolver.FindTestSource(s.entries[id])
+
+	if err != nil {
+		return false, sl, err
+	}
+
+	s.sourceMu.Lock()
+	s.sourceCache[id] = cachedSource{found: found, sl: sl}
+	s.sourceMu.Unlock()
+
+	return found, sl, nil
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	fmt.Println("serving on", addr)
+	return http.ListenAndServe(addr, s.handler())
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/api/tests", s.handleAPITests)
+	mux.HandleFunc("/api/tests/", s.handleAPITest)
+
+	return mux
+}
+
+// suiteCount is a left-pane summary row: a context (the "[sig-storage]"
+// style label TestCase.Context is parsed from) and how many of its tests
+// fall into each result bucket.
+type suiteCount struct {
+	Context string
+	Passed  int
+	Failed  int
+	Skipped int
+}
+
+func (s *Server) suiteCounts() []suiteCount {
+	counts := map[string]*suiteCount{}
+	var order []string
+
+	for _, e := range s.entries {
+		c, ok := counts[e.Context]
+
+		if !ok {
+			c = &suiteCount{Context: e.Context}
+			counts[e.Context] = c
+			order = append(order, e.Context)
+		}
+
+		switch {
+		case e.IsPassed():
+			c.Passed++
+		case e.IsFailed():
+			c.Failed++
+		case e.IsSkipped():
+			c.Skipped++
+		}
+	}
+
+	sort.Strings(order)
+
+	out := make([]suiteCount, 0, len(order))
+
+	for _, context := range order {
+		out = append(out, *counts[context])
+	}
+
+	return out
+}
+
+func (s *Server) filtered(tag, result string) []int {
+	var ids []int
+
+	for i, e := range s.entries {
+		if matchesFilter(e, tag, result) {
+			ids = append(ids, i)
+		}
+	}
+
+	return ids
+}
+
+type apiTest struct {
+	ID             int             `json:"id"`
+	Name           string          `json:"name"`
+	SimpleName     string          `json:"simpleName"`
+	Context        string          `json:"context"`
+	Tags           []string        `json:"tags"`
+	Status         string          `json:"status"`
+	Error          string          `json:"error,omitempty"`
+	SourceLocation *SourceLocation `json:"sourceLocation,omitempty"`
+}
+
+func (s *Server) toAPITest(id int, resolveSource bool) apiTest {
+	tc := s.entries[id]
+
+	at := apiTest{
+		ID:         id,
+		Name:       tc.Name,
+		SimpleName: tc.SimpleName,
+		Context:    tc.Context,
+		Tags:       tc.Tags,
+		Status:     string(tc.Test.Status),
+		Error:      testErrorMessage(tc),
+	}
+
+	if resolveSource {
+		if found, sl, err := s.resolveSource(id); err == nil && found {
+			at.SourceLocation = &sl
+		}
+	}
+
+	return at
+}
+
+func (s *Server) handleAPITests(w http.ResponseWriter, r *http.Request) {
+	tag := r.URL.Query().Get("tag")
+	result := r.URL.Query().Get("result")
+
+	if result == "" {
+		result = "all"
+	}
+
+	ids := s.filtered(tag, result)
+	tests := make([]apiTest, 0, len(ids))
+
+	for _, id := range ids {
+		tests = append(tests, s.toAPITest(id, false))
+	}
+
+	writeJSON(w, tests)
+}
+
+func (s *Server) handleAPITest(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/tests/")
+
+	id, err := strconv.Atoi(idStr)
+
+	if err != nil || id < 0 || id >= len(s.entries) {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeJSON(w, s.toAPITest(id, true))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type indexTestRow struct {
+	ID     int
+	Test   TestCase
+	Source SourceLocation
+	Found  bool
+}
+
+type indexData struct {
+	Suites []suiteCount
+	Tests  []indexTestRow
+	Tag    string
+	Result string
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	tag := r.URL.Query().Get("tag")
+	result := r.URL.Query().Get("result")
+
+	if result == "" {
+		result = "all"
+	}
+
+	ids := s.filtered(tag, result)
+	rows := make([]indexTestRow, 0, len(ids))
+
+	for _, id := range ids {
+		tc := s.entries[id]
+		found, sl, err := s.resolveSource(id)
+
+		if err != nil {
+			found = false
+		}
+
+		rows = append(rows, indexTestRow{ID: id, Test: tc, Source: sl, Found: found})
+	}
+
+	data := indexData{
+		Suites: s.suiteCounts(),
+		Tests:  rows,
+		Tag:    tag,
+		Result: result,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err := indexTemplate.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>openshift-test-result-filter</title>
+<style>
+  body { font-family: sans-serif; display: flex; margin: 0; }
+  nav { width: 220px; padding: 1em; border-right: 1px solid #ccc; }
+  nav div { margin-bottom: 0.5em; }
+  main { flex: 1; padding: 1em; }
+  form { margin-bottom: 1em; }
+  .test { border-bottom: 1px solid #eee; padding: 0.5em 0; }
+  .tag { background: #eef; border-radius: 3px; padding: 0 4px; margin-right: 4px; font-size: 0.8em; }
+  .passed { color: green; }
+  .failed { color: #b00; }
+  .skipped { color: #888; }
+</style>
+</head>
+<body>
+<nav>
+<h3>Suites</h3>
+{{range .Suites}}
+<div>{{.Context}}<br>
+<span class="passed">{{.Passed}} passed</span>,
+<span class="failed">{{.Failed}} failed</span>,
+<span class="skipped">{{.Skipped}} skipped</span>
+</div>
+{{end}}
+</nav>
+<main>
+<form>
+<input type="text" name="tag" placeholder="tag" value="{{.Tag}}">
+<select name="result">
+<option value="all" {{if eq .Result "all"}}selected{{end}}>all</option>
+<option value="passed" {{if eq .Result "passed"}}selected{{end}}>passed</option>
+<option value="failed" {{if eq .Result "failed"}}selected{{end}}>failed</option>
+<option value="skipped" {{if eq .Result "skipped"}}selected{{end}}>skipped</option>
+</select>
+<button type="submit">Filter</button>
+</form>
+{{range .Tests}}
+<div class="test {{.Test.Test.Status}}">
+<strong>{{.Test.SimpleName}}</strong>
+{{range .Test.Tags}}<span class="tag">{{.}}</span>{{end}}
+<div>{{.Test.Test.Status}}</div>
+{{if .Found}}<div><a href="{{.Source.GitHubLink}}">{{.Source.Path}}:{{.Source.LineNumber}}</a></div>{{end}}
+{{if .Test.Test.Error}}
+<details><summary>error</summary><pre>{{.Test.Test.Error}}</pre></details>
+{{end}}
+</div>
+{{end}}
+</main>
+</body>
+</html>
+`))